@@ -0,0 +1,322 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var builtinReplicaSetGVK = schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}
+
+// ParentRef identifies the controller that directly owns an object.
+type ParentRef struct {
+	UID types.UID
+}
+
+// OwnerResolverFunc extracts the direct controller reference of obj, as
+// understood by a particular controller kind. It returns a nil ParentRef
+// (and nil error) if obj has no controller that the indexer should walk up
+// to.
+type OwnerResolverFunc func(obj interface{}) (*ParentRef, error)
+
+// ControllerRefOwnerResolver is the default OwnerResolverFunc: it resolves
+// the parent via the standard metav1.OwnerReference with Controller=true,
+// which covers ReplicaSet, StatefulSet, Job, CronJob and most CRD
+// controllers (CloneSet, PodDecoration, CollaSet, ...) that follow the
+// built-in owner reference convention.
+func ControllerRefOwnerResolver(obj interface{}) (*ParentRef, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	controllerRef := metav1.GetControllerOf(accessor)
+	if controllerRef == nil {
+		return nil, nil
+	}
+	return &ParentRef{UID: controllerRef.UID}, nil
+}
+
+// ControlledPodsIndexer maintains an in-memory index of pods keyed by the
+// UID of their direct controller, so that PodsControlledBy can answer
+// "which pods does this object control" without listing and filtering the
+// whole pod cache on every call.
+//
+// Beyond the direct pod index, callers register owner resolvers per
+// GroupVersionKind via RegisterOwnerResolver so that intermediate
+// controller kinds (ReplicaSet, StatefulSet, Job, CRDs, ...) can be walked
+// transitively: PodsControlledBy(obj) follows the chain of registered
+// resolvers down from obj's UID until it reaches pods.
+type ControlledPodsIndexer struct {
+	mu sync.RWMutex
+
+	synced []cache.InformerSynced
+
+	pods *podShardIndex
+	// owners maps an intermediate controller's UID (e.g. a ReplicaSet) to
+	// the UID of the object that controls it (e.g. a Deployment), for every
+	// GVK registered via RegisterOwnerResolver, in both directions. Entries
+	// are added/refreshed as objects are observed, but deliberately never
+	// removed on delete: an intermediate object can be evicted from its
+	// informer cache before its children are, and we still need to resolve
+	// those children back to it.
+	owners *ownerShardIndex
+
+	resolvers map[schema.GroupVersionKind]struct{}
+
+	subscriptions []*podSetSubscription
+
+	disruptionMu sync.Mutex
+	// disruptionStats retains the DisruptionStats of pods that have been
+	// deleted and dropped from pods, keyed by the same direct-controller UID
+	// they were indexed under, so DisruptionStatsControlledBy can still
+	// report on them. See recordDisruptionStats.
+	disruptionStats map[types.UID]DisruptionStats
+}
+
+func newControlledPodsIndexer() *ControlledPodsIndexer {
+	return &ControlledPodsIndexer{
+		pods:            newPodShardIndex(),
+		owners:          newOwnerShardIndex(),
+		resolvers:       make(map[schema.GroupVersionKind]struct{}),
+		disruptionStats: make(map[types.UID]DisruptionStats),
+	}
+}
+
+// NewControlledPodsIndexer creates a ControlledPodsIndexer backed by the
+// given pod and ReplicaSet informers, with the Deployment -> ReplicaSet ->
+// Pod chain registered out of the box. The caller is responsible for
+// starting the underlying informer factory and, if needed, waiting for the
+// cache to sync via WaitForCacheSync. Additional controller kinds can be
+// plugged in afterwards via RegisterOwnerResolver.
+func NewControlledPodsIndexer(podsInformer coreinformers.PodInformer, rsInformer appsinformers.ReplicaSetInformer) (*ControlledPodsIndexer, error) {
+	p := newControlledPodsIndexer()
+	if err := p.addPodEventHandler(podsInformer.Informer()); err != nil {
+		return nil, err
+	}
+	if err := p.RegisterOwnerResolver(builtinReplicaSetGVK, rsInformer.Informer(), ControllerRefOwnerResolver); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewControlledPodsIndexerFromMetadata creates a ControlledPodsIndexer whose
+// ReplicaSet side of the owner chain is backed by a metadata-only informer
+// (metav1.PartialObjectMetadata, as produced by a
+// metadatainformer.SharedInformerFactory) instead of a fully typed
+// ReplicaSet informer. At the scale clusterloader2 runs against, resolving
+// PodsControlledBy only needs a ReplicaSet's OwnerReferences, UID, Name and
+// Namespace, so this avoids holding full ReplicaSet specs/statuses in the
+// informer cache. It otherwise behaves identically to
+// NewControlledPodsIndexer.
+func NewControlledPodsIndexerFromMetadata(podsInformer coreinformers.PodInformer, rsMetadataInformer informers.GenericInformer) (*ControlledPodsIndexer, error) {
+	p := newControlledPodsIndexer()
+	if err := p.addPodEventHandler(podsInformer.Informer()); err != nil {
+		return nil, err
+	}
+	if err := p.RegisterOwnerResolver(builtinReplicaSetGVK, rsMetadataInformer.Informer(), ControllerRefOwnerResolver); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RegisterOwnerResolver wires informer into the indexer as an intermediate
+// link in the owner chain: objects served by informer are assumed to be
+// (in)direct parents of pods, and resolve is used to find each object's own
+// controller so PodsControlledBy can walk the chain further up. gvk is used
+// only to reject registering the same controller kind twice; resolution
+// itself is keyed purely by UID, so informer can back a typed, dynamic, or
+// metadata-only client.
+//
+// This lets clusterloader2 measurements resolve pods transitively owned by
+// non-Deployment workloads (StatefulSet, Job, CronJob, CloneSet, operator
+// CRDs such as PodDecoration or CollaSet, ...) by registering the relevant
+// informer once, without the core indexer having to know about the kind.
+func (p *ControlledPodsIndexer) RegisterOwnerResolver(gvk schema.GroupVersionKind, informer cache.SharedIndexInformer, resolve OwnerResolverFunc) error {
+	p.mu.Lock()
+	if _, ok := p.resolvers[gvk]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("owner resolver for %s is already registered", gvk)
+	}
+	p.resolvers[gvk] = struct{}{}
+	p.mu.Unlock()
+
+	handler := func(obj interface{}) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return
+		}
+		parent, err := resolve(obj)
+		if err != nil || parent == nil {
+			return
+		}
+		p.owners.set(accessor.GetUID(), parent.UID)
+		p.notifyResync(parent.UID)
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handler,
+		UpdateFunc: func(_, newObj interface{}) { handler(newObj) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register owner resolver for %s: %w", gvk, err)
+	}
+	p.synced = append(p.synced, informer.HasSynced)
+	return nil
+}
+
+// WaitForCacheSync waits for the pod informer and every informer registered
+// via RegisterOwnerResolver to sync, or for ctx to be done.
+func (p *ControlledPodsIndexer) WaitForCacheSync(ctx context.Context) bool {
+	p.mu.RLock()
+	synced := append([]cache.InformerSynced(nil), p.synced...)
+	p.mu.RUnlock()
+	return cache.WaitForCacheSync(ctx.Done(), synced...)
+}
+
+func (p *ControlledPodsIndexer) addPodEventHandler(informer cache.SharedIndexInformer) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    p.addPod,
+		UpdateFunc: p.updatePod,
+		DeleteFunc: p.deletePod,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register pod event handler: %w", err)
+	}
+	p.synced = append(p.synced, informer.HasSynced)
+	return nil
+}
+
+func (p *ControlledPodsIndexer) addPod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	p.indexPod(pod)
+	p.notify(PodSetEventAdd, pod)
+}
+
+func (p *ControlledPodsIndexer) updatePod(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	p.unindexPod(oldPod)
+	p.indexPod(newPod)
+	p.notify(PodSetEventUpdate, newPod)
+}
+
+func (p *ControlledPodsIndexer) deletePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		pod, ok = tombstone.Obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+	}
+	p.recordDisruptionStats(pod)
+	p.unindexPod(pod)
+	p.notify(PodSetEventDelete, pod)
+}
+
+func (p *ControlledPodsIndexer) indexPod(pod *corev1.Pod) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		return
+	}
+	p.pods.add(controllerRef.UID, pod)
+}
+
+func (p *ControlledPodsIndexer) unindexPod(pod *corev1.Pod) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		return
+	}
+	p.pods.remove(controllerRef.UID, pod.UID)
+}
+
+// podsByUID returns the pods directly controlled by uid. The slice is
+// never mutated in place once published, so callers may hold onto it
+// without copying.
+func (p *ControlledPodsIndexer) podsByUID(uid types.UID) []*corev1.Pod {
+	return p.pods.load(uid)
+}
+
+// childrenOf returns the UIDs of objects known, via a registered owner
+// resolver, to be directly controlled by uid.
+func (p *ControlledPodsIndexer) childrenOf(uid types.UID) []types.UID {
+	return p.owners.childrenOf(uid)
+}
+
+// controlledUIDs returns rootUID plus every UID reachable by walking the
+// registered owner resolvers down from it, i.e. the full set of UIDs whose
+// directly-controlled pods (if any) are controlled by rootUID.
+func (p *ControlledPodsIndexer) controlledUIDs(rootUID types.UID) []types.UID {
+	visited := map[types.UID]bool{rootUID: true}
+	queue := []types.UID{rootUID}
+	uids := []types.UID{rootUID}
+
+	for len(queue) > 0 {
+		uid := queue[0]
+		queue = queue[1:]
+
+		for _, child := range p.childrenOf(uid) {
+			if !visited[child] {
+				visited[child] = true
+				queue = append(queue, child)
+				uids = append(uids, child)
+			}
+		}
+	}
+	return uids
+}
+
+// PodsControlledBy returns the pods transitively controlled by obj: obj
+// itself, plus every object reachable by walking the registered owner
+// resolvers down from obj's UID, as far as pods. obj may be typed,
+// unstructured, or metadata-only; anything that doesn't implement
+// metav1.Object is an error.
+func (p *ControlledPodsIndexer) PodsControlledBy(obj interface{}) ([]*corev1.Pod, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, fmt.Errorf("object of type %T does not implement metav1.Object: %w", obj, err)
+	}
+
+	var pods []*corev1.Pod
+	for _, uid := range p.controlledUIDs(accessor.GetUID()) {
+		pods = append(pods, p.podsByUID(uid)...)
+	}
+	return pods, nil
+}
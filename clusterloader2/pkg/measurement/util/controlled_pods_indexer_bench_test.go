@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	benchNumDeployments = 30
+	benchNumReplicaSets = 5000
+	benchNumPods        = 100000
+	benchP99Threshold   = 5 * time.Millisecond
+	benchWriterCount    = 8
+)
+
+// buildSyntheticIndexer builds a ControlledPodsIndexer directly (bypassing
+// informers) with benchNumDeployments Deployments, benchNumReplicaSets
+// ReplicaSets spread evenly across them, and benchNumPods pods spread
+// evenly across the ReplicaSets, along with the Deployment UIDs to query.
+func buildSyntheticIndexer(b *testing.B) (*ControlledPodsIndexer, []types.UID) {
+	b.Helper()
+
+	p := newControlledPodsIndexer()
+
+	deploymentUIDs := make([]types.UID, benchNumDeployments)
+	for i := range deploymentUIDs {
+		deploymentUIDs[i] = types.UID(fmt.Sprintf("deployment-%d", i))
+	}
+
+	for i := 0; i < benchNumReplicaSets; i++ {
+		rsUID := types.UID(fmt.Sprintf("rs-%d", i))
+		p.owners.set(rsUID, deploymentUIDs[i%benchNumDeployments])
+	}
+
+	for i := 0; i < benchNumPods; i++ {
+		rsUID := types.UID(fmt.Sprintf("rs-%d", i%benchNumReplicaSets))
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("pod-%d", i),
+				UID:  types.UID(fmt.Sprintf("pod-%d", i)),
+				OwnerReferences: []metav1.OwnerReference{
+					{UID: rsUID, Controller: boolPtr(true)},
+				},
+			},
+		}
+		p.indexPod(pod)
+	}
+
+	return p, deploymentUIDs
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+// runConcurrentWriters starts benchWriterCount goroutines that continuously
+// add and delete synthetic pods and refresh ReplicaSet owner edges, standing
+// in for the live informer Add/Update/Delete events that PodsControlledBy
+// must stay responsive alongside at clusterloader2 scale. It returns a
+// function that stops the writers and waits for them to exit.
+func runConcurrentWriters(p *ControlledPodsIndexer, deploymentUIDs []types.UID) func() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < benchWriterCount; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var n int64
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				i := atomic.AddInt64(&n, 1)
+				rsIndex := int(i) % benchNumReplicaSets
+				rsUID := types.UID(fmt.Sprintf("rs-%d", rsIndex))
+
+				// Simulate a ReplicaSet informer Update re-resolving its
+				// owner reference.
+				p.owners.set(rsUID, deploymentUIDs[rsIndex%benchNumDeployments])
+
+				// Simulate a pod informer Add immediately followed by a
+				// Delete, as happens continuously under churn.
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: fmt.Sprintf("writer-%d-pod-%d", w, i),
+						UID:  types.UID(fmt.Sprintf("writer-%d-pod-%d", w, i)),
+						OwnerReferences: []metav1.OwnerReference{
+							{UID: rsUID, Controller: boolPtr(true)},
+						},
+					},
+				}
+				p.indexPod(pod)
+				p.unindexPod(pod)
+			}
+		}(w)
+	}
+
+	return func() {
+		close(stop)
+		wg.Wait()
+	}
+}
+
+// BenchmarkPodsControlledBy_Parallel drives concurrent PodsControlledBy
+// lookups against a synthetic cluster of benchNumPods pods across
+// benchNumReplicaSets ReplicaSets under benchNumDeployments Deployments,
+// with benchWriterCount goroutines continuously mutating the index in the
+// background, and fails if p99 lookup latency exceeds benchP99Threshold.
+// This guards against the single map+mutex design, which serializes every
+// lookup against every informer event and can stall measurement collection
+// under event storms at clusterloader2 scale.
+func BenchmarkPodsControlledBy_Parallel(b *testing.B) {
+	p, deploymentUIDs := buildSyntheticIndexer(b)
+
+	stopWriters := runConcurrentWriters(p, deploymentUIDs)
+	defer stopWriters()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	var i int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					UID: deploymentUIDs[atomic.AddInt64(&i, 1)%benchNumDeployments],
+				},
+			}
+
+			start := time.Now()
+			if _, err := p.PodsControlledBy(deployment); err != nil {
+				b.Fatalf("PodsControlledBy() error = %v", err)
+			}
+			elapsed := time.Since(start)
+
+			mu.Lock()
+			latencies = append(latencies, elapsed)
+			mu.Unlock()
+		}
+	})
+	b.StopTimer()
+
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p99 := latencies[(len(latencies)*99)/100]
+	b.ReportMetric(float64(p99.Microseconds()), "p99-us")
+	if p99 > benchP99Threshold {
+		b.Fatalf("p99 PodsControlledBy() latency = %v, want <= %v", p99, benchP99Threshold)
+	}
+}
@@ -27,11 +27,16 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
 )
 
+var replicaSetsResource = appsv1.SchemeGroupVersion.WithResource("replicasets")
+
 const (
 	ns1 = "namespace-1"
 )
@@ -105,8 +110,51 @@ var (
 			},
 		},
 	}
+
+	workloadKind = schema.GroupVersionKind{Group: "custom.example.com", Version: "v1", Kind: "Workload"}
+	workload     = &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": workloadKind.GroupVersion().String(),
+		"kind":       workloadKind.Kind,
+		"metadata": map[string]interface{}{
+			"name":      "workload-1",
+			"namespace": ns1,
+			"uid":       "uid-6",
+		},
+	}}
+
+	workloadStatefulSet = &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sts-1",
+			Namespace: ns1,
+			UID:       types.UID("uid-7"),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(workload, workloadKind),
+			},
+		},
+	}
+
+	statefulSetPod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-3",
+			Namespace: ns1,
+			UID:       types.UID("uid-8"),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(workloadStatefulSet, appsv1.SchemeGroupVersion.WithKind("StatefulSet")),
+			},
+		},
+	}
 )
 
+// newReplicaSetMetadataScheme returns a scheme suitable for a
+// metadatafake.FakeMetadataClient backing ReplicaSet metadata: the object
+// tracker needs metav1.PartialObjectMetadata itself registered, which
+// metadatafake.NewTestScheme's bare runtime.NewScheme() doesn't do.
+func newReplicaSetMetadataScheme() *runtime.Scheme {
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	return scheme
+}
+
 func toUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
 	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
 	if err != nil {
@@ -212,16 +260,12 @@ func TestControlledPodsIndexer_PodsControlledBy_ReplicasetDeleted(t *testing.T)
 		t.Fatalf("unexpected error during replicaset deletion: %v", err)
 	}
 
-	// Sleeping in order for the replicaset informer to catch up with the changes.
-	time.Sleep(1 * time.Second)
-
-	want := []*corev1.Pod{replicaSetPod}
-	got, err := p.PodsControlledBy(deployment)
+	got, err := p.WaitForPodCount(mustTimeoutCtx(t, ctx), deployment, 1)
 	if err != nil {
-		t.Errorf("PodsIndexer.PodsControlledBy() error = %v, wantErr %v", err, nil)
-		return
+		t.Fatalf("WaitForPodCount() error = %v", err)
 	}
 
+	want := []*corev1.Pod{replicaSetPod}
 	if !equality.Semantic.DeepEqual(got, want) {
 		t.Errorf("PodsIndexer.PodsControlledBy() = %v, want %v", got, want)
 	}
@@ -243,9 +287,15 @@ func TestControlledPodsIndexer_PodsControlledBy_PodUpdate(t *testing.T) {
 	if err := fakeClient.AppsV1().ReplicaSets(ns1).Delete(ctx, replicaSet.Name, metav1.DeleteOptions{}); err != nil {
 		t.Fatalf("unexpected error during replicaset deletion: %v", err)
 	}
+	if _, err := p.WaitForPodCount(mustTimeoutCtx(t, ctx), deployment, 1); err != nil {
+		t.Fatalf("WaitForPodCount() error = %v", err)
+	}
 
-	// Sleeping in order for the replicaset informer to catch up with the changes.
-	time.Sleep(1 * time.Second)
+	events, cancelObserve, err := p.Observe(deployment)
+	if err != nil {
+		t.Fatalf("Observe() error = %v", err)
+	}
+	defer cancelObserve()
 
 	changedReplicaSetPod := replicaSetPod.DeepCopy()
 	changedReplicaSetPod.Status.Phase = "Running"
@@ -253,8 +303,12 @@ func TestControlledPodsIndexer_PodsControlledBy_PodUpdate(t *testing.T) {
 		t.Fatalf("unexpected error during pod update: %v", err)
 	}
 
-	// Sleeping in order for the pod informer to catch up with the changes.
-	time.Sleep(1 * time.Second)
+	waitCtx := mustTimeoutCtx(t, ctx)
+	select {
+	case <-events:
+	case <-waitCtx.Done():
+		t.Fatalf("timed out waiting for pod update event")
+	}
 
 	want := []*corev1.Pod{changedReplicaSetPod.DeepCopy()}
 	got, err := p.PodsControlledBy(deployment)
@@ -267,3 +321,144 @@ func TestControlledPodsIndexer_PodsControlledBy_PodUpdate(t *testing.T) {
 		t.Errorf("PodsIndexer.PodsControlledBy() = %v, want %v", got, want)
 	}
 }
+
+// mustTimeoutCtx returns a context bounded to a sane test timeout, so that a
+// regression in event delivery fails the test instead of hanging it.
+func mustTimeoutCtx(t *testing.T, parent context.Context) context.Context {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newMockedControlledPodsIndexerFromMetadata builds a ControlledPodsIndexer
+// whose ReplicaSet side is backed by a metadata-only informer, mirroring
+// newMockedControlledPodsIndexer for the typed case.
+func newMockedControlledPodsIndexerFromMetadata(ctx context.Context, t *testing.T, podsClient *fake.Clientset, metadataClient *metadatafake.FakeMetadataClient) (*ControlledPodsIndexer, error) {
+	informerFactory := informers.NewSharedInformerFactory(podsClient, 0 /* resyncPeriod */)
+	podsInformer := informerFactory.Core().V1().Pods()
+
+	metadataInformerFactory := metadatainformer.NewSharedInformerFactory(metadataClient, 0 /* resyncPeriod */)
+	rsMetadataInformer := metadataInformerFactory.ForResource(replicaSetsResource)
+
+	p, err := NewControlledPodsIndexerFromMetadata(podsInformer, rsMetadataInformer)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+	informerFactory.Start(ctx.Done())
+	metadataInformerFactory.Start(ctx.Done())
+	if !p.WaitForCacheSync(ctx) {
+		t.Fatalf("failed to sync informer")
+	}
+
+	return p, nil
+}
+
+// TestControlledPodsIndexer_PodsControlledBy_FromMetadata proves that
+// backing the ReplicaSet side of the indexer with PartialObjectMetadata
+// instead of typed ReplicaSets returns the same pod sets for the
+// Deployment -> ReplicaSet -> Pod chain.
+func TestControlledPodsIndexer_PodsControlledBy_FromMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	podsClient := fake.NewSimpleClientset(replicaSetPod)
+	metadataClient := metadatafake.NewSimpleMetadataClient(newReplicaSetMetadataScheme(), toPartialObjectMetadata(replicaSet, replicaSetKind))
+
+	p, err := newMockedControlledPodsIndexerFromMetadata(ctx, t, podsClient, metadataClient)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+
+	want := []*corev1.Pod{replicaSetPod}
+	got, err := p.PodsControlledBy(deployment)
+	if err != nil {
+		t.Errorf("PodsIndexer.PodsControlledBy() error = %v, wantErr %v", err, nil)
+		return
+	}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("PodsIndexer.PodsControlledBy() = %v, want %v", got, want)
+	}
+}
+
+// TestControlledPodsIndexer_PodsControlledBy_FromMetadata_ReplicasetDeleted
+// mirrors TestControlledPodsIndexer_PodsControlledBy_ReplicasetDeleted for
+// the metadata-backed ReplicaSet informer.
+func TestControlledPodsIndexer_PodsControlledBy_FromMetadata_ReplicasetDeleted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	podsClient := fake.NewSimpleClientset(replicaSetPod)
+	metadataClient := metadatafake.NewSimpleMetadataClient(newReplicaSetMetadataScheme(), toPartialObjectMetadata(replicaSet, replicaSetKind))
+
+	p, err := newMockedControlledPodsIndexerFromMetadata(ctx, t, podsClient, metadataClient)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+
+	if err := metadataClient.Resource(replicaSetsResource).Namespace(ns1).Delete(ctx, replicaSet.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error during replicaset deletion: %v", err)
+	}
+
+	got, err := p.WaitForPodCount(mustTimeoutCtx(t, ctx), deployment, 1)
+	if err != nil {
+		t.Fatalf("WaitForPodCount() error = %v", err)
+	}
+
+	want := []*corev1.Pod{replicaSetPod}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("PodsIndexer.PodsControlledBy() = %v, want %v", got, want)
+	}
+}
+
+// TestControlledPodsIndexer_RegisterOwnerResolver_CustomKind proves that
+// plugging in an informer for a controller kind the indexer has no built-in
+// knowledge of (here a StatefulSet standing in for any CRD controller) is
+// enough to resolve pods transitively owned by that kind's own controller.
+func TestControlledPodsIndexer_RegisterOwnerResolver_CustomKind(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClient := fake.NewSimpleClientset(workloadStatefulSet, statefulSetPod)
+	informerFactory := informers.NewSharedInformerFactory(fakeClient, 0 /* resyncPeriod */)
+	podsInformer := informerFactory.Core().V1().Pods()
+	rsInformer := informerFactory.Apps().V1().ReplicaSets()
+	stsInformer := informerFactory.Apps().V1().StatefulSets()
+
+	p, err := NewControlledPodsIndexer(podsInformer, rsInformer)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+	if err := p.RegisterOwnerResolver(appsv1.SchemeGroupVersion.WithKind("StatefulSet"), stsInformer.Informer(), ControllerRefOwnerResolver); err != nil {
+		t.Fatalf("failed to register StatefulSet owner resolver: %v", err)
+	}
+
+	informerFactory.Start(ctx.Done())
+	if !p.WaitForCacheSync(ctx) {
+		t.Fatalf("failed to sync informer")
+	}
+
+	want := []*corev1.Pod{statefulSetPod}
+	got, err := p.PodsControlledBy(workload)
+	if err != nil {
+		t.Errorf("PodsIndexer.PodsControlledBy() error = %v, wantErr %v", err, nil)
+		return
+	}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("PodsIndexer.PodsControlledBy() = %v, want %v", got, want)
+	}
+}
+
+func toPartialObjectMetadata(obj metav1.Object, gvk schema.GroupVersionKind) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gvk.GroupVersion().String(),
+			Kind:       gvk.Kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            obj.GetName(),
+			Namespace:       obj.GetNamespace(),
+			UID:             obj.GetUID(),
+			OwnerReferences: obj.GetOwnerReferences(),
+		},
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ownerShardCount is the number of stripes the owner-chain graph is split
+// across, mirroring podShardIndex.
+const ownerShardCount = 256
+
+// ownerShardIndex maintains the owner-chain graph built by
+// RegisterOwnerResolver: a forward edge from each intermediate controller's
+// UID to its own parent's UID, plus the reverse multimap needed to answer
+// "what does uid directly control" without scanning every known edge.
+//
+// The forward edges are sharded by child UID and the reverse multimap by
+// parent UID, so that - like podShardIndex - RegisterOwnerResolver's
+// per-event updates and PodsControlledBy's per-UID lookups spread their
+// locking across many independent shards instead of funneling through one
+// map+mutex.
+type ownerShardIndex struct {
+	parents  [ownerShardCount]ownerParentShard
+	children [ownerShardCount]ownerChildrenShard
+}
+
+type ownerParentShard struct {
+	mu      sync.RWMutex
+	parents map[types.UID]types.UID
+}
+
+type ownerChildrenShard struct {
+	mu      sync.RWMutex
+	entries map[types.UID]*atomic.Pointer[[]types.UID]
+}
+
+func newOwnerShardIndex() *ownerShardIndex {
+	idx := &ownerShardIndex{}
+	for i := range idx.parents {
+		idx.parents[i].parents = make(map[types.UID]types.UID)
+	}
+	for i := range idx.children {
+		idx.children[i].entries = make(map[types.UID]*atomic.Pointer[[]types.UID])
+	}
+	return idx
+}
+
+// set records that child is directly controlled by parent, replacing
+// whatever parent was previously recorded for child.
+func (idx *ownerShardIndex) set(child, parent types.UID) {
+	pshard := &idx.parents[shardFor(child, ownerShardCount)]
+	pshard.mu.Lock()
+	old, had := pshard.parents[child]
+	if had && old == parent {
+		pshard.mu.Unlock()
+		return
+	}
+	pshard.parents[child] = parent
+	pshard.mu.Unlock()
+
+	if had {
+		idx.removeChild(old, child)
+	}
+	idx.addChild(parent, child)
+}
+
+// parentOf returns the parent UID recorded for child, if any.
+func (idx *ownerShardIndex) parentOf(child types.UID) (types.UID, bool) {
+	pshard := &idx.parents[shardFor(child, ownerShardCount)]
+	pshard.mu.RLock()
+	defer pshard.mu.RUnlock()
+	parent, ok := pshard.parents[child]
+	return parent, ok
+}
+
+// childrenOf returns the UIDs directly controlled by parent, as recorded by
+// set.
+func (idx *ownerShardIndex) childrenOf(parent types.UID) []types.UID {
+	ptr := idx.childrenEntry(parent, false)
+	if ptr == nil {
+		return nil
+	}
+	children := ptr.Load()
+	if children == nil {
+		return nil
+	}
+	return *children
+}
+
+func (idx *ownerShardIndex) childrenEntry(parent types.UID, createIfMissing bool) *atomic.Pointer[[]types.UID] {
+	cshard := &idx.children[shardFor(parent, ownerShardCount)]
+
+	cshard.mu.RLock()
+	ptr := cshard.entries[parent]
+	cshard.mu.RUnlock()
+	if ptr != nil || !createIfMissing {
+		return ptr
+	}
+
+	cshard.mu.Lock()
+	defer cshard.mu.Unlock()
+	if ptr = cshard.entries[parent]; ptr == nil {
+		ptr = &atomic.Pointer[[]types.UID]{}
+		cshard.entries[parent] = ptr
+	}
+	return ptr
+}
+
+func (idx *ownerShardIndex) addChild(parent, child types.UID) {
+	ptr := idx.childrenEntry(parent, true)
+	for {
+		old := ptr.Load()
+		children := derefUIDs(old)
+		for _, c := range children {
+			if c == child {
+				return
+			}
+		}
+		next := append(append([]types.UID(nil), children...), child)
+		if ptr.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+func (idx *ownerShardIndex) removeChild(parent, child types.UID) {
+	ptr := idx.childrenEntry(parent, false)
+	if ptr == nil {
+		return
+	}
+	for {
+		old := ptr.Load()
+		children := derefUIDs(old)
+		i := indexOfUID(children, child)
+		if i < 0 {
+			return
+		}
+		next := make([]types.UID, 0, len(children)-1)
+		next = append(next, children[:i]...)
+		next = append(next, children[i+1:]...)
+		if ptr.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+func derefUIDs(uids *[]types.UID) []types.UID {
+	if uids == nil {
+		return nil
+	}
+	return *uids
+}
+
+func indexOfUID(uids []types.UID, uid types.UID) int {
+	for i, u := range uids {
+		if u == uid {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podDisruptionConditionType is the PodCondition.Type surfaced by upstream
+// kubernetes on pods that were removed through something other than a
+// normal, graceful termination.
+const podDisruptionConditionType corev1.PodConditionType = "DisruptionTarget"
+
+// Well-known PodDisruptionCondition reasons. These mirror the reasons the
+// scheduler, taint manager, eviction API, PodGC and kubelet set on the
+// DisruptionTarget condition.
+const (
+	DisruptionReasonPreemptionByScheduler  = "PreemptionByKubeScheduler"
+	DisruptionReasonDeletionByTaintManager = "DeletionByTaintManager"
+	DisruptionReasonEvictionByEvictionAPI  = "EvictionByEvictionAPI"
+	DisruptionReasonDeletionByPodGC        = "DeletionByPodGC"
+	DisruptionReasonTerminationByKubelet   = "TerminationByKubelet"
+)
+
+// DisruptionStats aggregates, across a set of pods, how many carried a
+// PodDisruptionCondition and why.
+type DisruptionStats struct {
+	// ReasonCounts maps a DisruptionTarget condition reason (e.g.
+	// DisruptionReasonPreemptionByScheduler) to the number of pods that
+	// carried it.
+	ReasonCounts map[string]int
+	// LastTransitionTimes maps a reason to the most recent
+	// LastTransitionTime observed among the pods that carried it.
+	LastTransitionTimes map[string]metav1.Time
+}
+
+func newDisruptionStats() DisruptionStats {
+	return DisruptionStats{
+		ReasonCounts:        make(map[string]int),
+		LastTransitionTimes: make(map[string]metav1.Time),
+	}
+}
+
+func (s *DisruptionStats) record(condition corev1.PodCondition) {
+	s.ReasonCounts[condition.Reason]++
+	if last, ok := s.LastTransitionTimes[condition.Reason]; !ok || condition.LastTransitionTime.After(last.Time) {
+		s.LastTransitionTimes[condition.Reason] = condition.LastTransitionTime
+	}
+}
+
+func (s *DisruptionStats) merge(other DisruptionStats) {
+	for reason, count := range other.ReasonCounts {
+		s.ReasonCounts[reason] += count
+	}
+	for reason, t := range other.LastTransitionTimes {
+		if last, ok := s.LastTransitionTimes[reason]; !ok || t.After(last.Time) {
+			s.LastTransitionTimes[reason] = t
+		}
+	}
+}
+
+// disruptionStatsOf extracts the DisruptionStats carried by pod's own
+// conditions, or empty (non-nil) stats if it has none.
+func disruptionStatsOf(pod *corev1.Pod) DisruptionStats {
+	stats := newDisruptionStats()
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type != podDisruptionConditionType || condition.Reason == "" {
+			continue
+		}
+		stats.record(condition)
+	}
+	return stats
+}
+
+// recordDisruptionStats folds pod's disruption conditions into the stats
+// retained for its direct controller. It's called from deletePod, before the
+// pod is dropped from the live index, so that a pod disrupted (preempted,
+// evicted, ...) and then actually removed from the API server - the common
+// case DisruptionStatsControlledBy exists to report on - isn't silently lost
+// once unindexPod runs.
+func (p *ControlledPodsIndexer) recordDisruptionStats(pod *corev1.Pod) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		return
+	}
+	stats := disruptionStatsOf(pod)
+	if len(stats.ReasonCounts) == 0 {
+		return
+	}
+
+	p.disruptionMu.Lock()
+	defer p.disruptionMu.Unlock()
+	if existing, ok := p.disruptionStats[controllerRef.UID]; ok {
+		existing.merge(stats)
+		p.disruptionStats[controllerRef.UID] = existing
+		return
+	}
+	p.disruptionStats[controllerRef.UID] = stats
+}
+
+// retainedDisruptionStats returns the disruption stats retained for uid from
+// pods that have since been deleted and dropped from the live index, or the
+// zero value if none are retained.
+func (p *ControlledPodsIndexer) retainedDisruptionStats(uid types.UID) (DisruptionStats, bool) {
+	p.disruptionMu.Lock()
+	defer p.disruptionMu.Unlock()
+	stats, ok := p.disruptionStats[uid]
+	return stats, ok
+}
+
+// DisruptionStatsControlledBy aggregates the DisruptionTarget pod condition
+// across all pods transitively controlled by obj (see
+// ControlledPodsIndexer.PodsControlledBy), so measurements can report why
+// pods went away during a run (preemption, taint eviction, PodGC, ...)
+// instead of only tracking readiness. This includes pods that have since
+// been deleted: their disruption conditions are retained (see
+// recordDisruptionStats) rather than lost when they drop out of the live
+// pod index.
+func (p *ControlledPodsIndexer) DisruptionStatsControlledBy(obj interface{}) (DisruptionStats, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return DisruptionStats{}, fmt.Errorf("object of type %T does not implement metav1.Object: %w", obj, err)
+	}
+
+	stats := newDisruptionStats()
+	for _, uid := range p.controlledUIDs(accessor.GetUID()) {
+		for _, pod := range p.podsByUID(uid) {
+			stats.merge(disruptionStatsOf(pod))
+		}
+		if retained, ok := p.retainedDisruptionStats(uid); ok {
+			stats.merge(retained)
+		}
+	}
+	return stats, nil
+}
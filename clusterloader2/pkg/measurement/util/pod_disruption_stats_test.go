@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestControlledPodsIndexer_DisruptionStatsControlledBy(t *testing.T) {
+	preemptedAt := metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	evictedAt := metav1.NewTime(time.Date(2022, 1, 1, 0, 5, 0, 0, time.UTC))
+
+	preemptedPod := replicaSetPod.DeepCopy()
+	preemptedPod.Name = "pod-preempted"
+	preemptedPod.UID = types.UID("uid-preempted")
+	preemptedPod.Status.Conditions = []corev1.PodCondition{
+		{
+			Type:               podDisruptionConditionType,
+			Status:             corev1.ConditionTrue,
+			Reason:             DisruptionReasonPreemptionByScheduler,
+			LastTransitionTime: preemptedAt,
+		},
+	}
+
+	evictedPod := replicaSetPod.DeepCopy()
+	evictedPod.Name = "pod-evicted"
+	evictedPod.UID = types.UID("uid-evicted")
+	evictedPod.Status.Conditions = []corev1.PodCondition{
+		{
+			Type:               podDisruptionConditionType,
+			Status:             corev1.ConditionTrue,
+			Reason:             DisruptionReasonEvictionByEvictionAPI,
+			LastTransitionTime: evictedAt,
+		},
+	}
+
+	readyPod := replicaSetPod.DeepCopy()
+	readyPod.Name = "pod-ready"
+	readyPod.UID = types.UID("uid-ready")
+	readyPod.Status.Conditions = []corev1.PodCondition{
+		{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClient := fake.NewSimpleClientset(deployment, replicaSet, preemptedPod, evictedPod, readyPod)
+	p, err := newMockedControlledPodsIndexer(ctx, t, fakeClient)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+
+	got, err := p.DisruptionStatsControlledBy(deployment)
+	if err != nil {
+		t.Fatalf("DisruptionStatsControlledBy() error = %v", err)
+	}
+
+	want := DisruptionStats{
+		ReasonCounts: map[string]int{
+			DisruptionReasonPreemptionByScheduler: 1,
+			DisruptionReasonEvictionByEvictionAPI: 1,
+		},
+		LastTransitionTimes: map[string]metav1.Time{
+			DisruptionReasonPreemptionByScheduler: preemptedAt,
+			DisruptionReasonEvictionByEvictionAPI: evictedAt,
+		},
+	}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("DisruptionStatsControlledBy() = %v, want %v", got, want)
+	}
+}
+
+func TestControlledPodsIndexer_DisruptionStatsControlledBy_PodDeleted(t *testing.T) {
+	preemptedAt := metav1.NewTime(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	preemptedPod := replicaSetPod.DeepCopy()
+	preemptedPod.Name = "pod-preempted"
+	preemptedPod.UID = types.UID("uid-preempted")
+	preemptedPod.Status.Conditions = []corev1.PodCondition{
+		{
+			Type:               podDisruptionConditionType,
+			Status:             corev1.ConditionTrue,
+			Reason:             DisruptionReasonPreemptionByScheduler,
+			LastTransitionTime: preemptedAt,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClient := fake.NewSimpleClientset(deployment, replicaSet, preemptedPod)
+	p, err := newMockedControlledPodsIndexer(ctx, t, fakeClient)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+
+	if err := fakeClient.CoreV1().Pods(ns1).Delete(ctx, preemptedPod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("unexpected error during pod deletion: %v", err)
+	}
+	if _, err := p.WaitForPodCount(mustTimeoutCtx(t, ctx), deployment, 0); err != nil {
+		t.Fatalf("WaitForPodCount() error = %v", err)
+	}
+
+	got, err := p.DisruptionStatsControlledBy(deployment)
+	if err != nil {
+		t.Fatalf("DisruptionStatsControlledBy() error = %v", err)
+	}
+
+	want := DisruptionStats{
+		ReasonCounts: map[string]int{
+			DisruptionReasonPreemptionByScheduler: 1,
+		},
+		LastTransitionTimes: map[string]metav1.Time{
+			DisruptionReasonPreemptionByScheduler: preemptedAt,
+		},
+	}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("DisruptionStatsControlledBy() = %v, want %v", got, want)
+	}
+}
+
+func TestControlledPodsIndexer_DisruptionStatsControlledBy_NoCondition(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fakeClient := fake.NewSimpleClientset(deployment, replicaSet, replicaSetPod)
+	p, err := newMockedControlledPodsIndexer(ctx, t, fakeClient)
+	if err != nil {
+		t.Fatalf("failed to create ControlledPodsIndexer instance: %v", err)
+	}
+
+	got, err := p.DisruptionStatsControlledBy(deployment)
+	if err != nil {
+		t.Fatalf("DisruptionStatsControlledBy() error = %v", err)
+	}
+
+	want := newDisruptionStats()
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("DisruptionStatsControlledBy() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podSetEventBufferSize bounds how many events a slow subscriber can fall
+// behind by before new events for it are dropped. Subscribers that need a
+// consistent view should re-read via PodsControlledBy on every event rather
+// than rely on the event stream being complete.
+const podSetEventBufferSize = 64
+
+// PodSetEventType describes how a pod's membership in an observed pod set
+// changed.
+type PodSetEventType string
+
+const (
+	PodSetEventAdd    PodSetEventType = "Add"
+	PodSetEventUpdate PodSetEventType = "Update"
+	PodSetEventDelete PodSetEventType = "Delete"
+	// PodSetEventResync signals that the owner-chain topology feeding a
+	// subscription's pod set changed - a RegisterOwnerResolver-registered
+	// informer resolved a new or changed edge - without any pod itself
+	// being added, updated, or deleted. Pod is nil for this event type;
+	// subscribers should treat it like any other event and re-evaluate via
+	// PodsControlledBy.
+	PodSetEventResync PodSetEventType = "Resync"
+)
+
+// PodSetEvent is emitted on the channel returned by
+// ControlledPodsIndexer.Observe whenever a pod transitively controlled by
+// the observed object is added, updated, or deleted.
+type PodSetEvent struct {
+	Type PodSetEventType
+	Pod  *corev1.Pod
+}
+
+// CancelFunc stops a subscription started by Observe. It is safe to call
+// more than once.
+type CancelFunc func()
+
+type podSetSubscription struct {
+	rootUID types.UID
+	ch      chan PodSetEvent
+}
+
+// Observe subscribes to Add/Update/Delete events for the set of pods
+// transitively controlled by obj, as computed by PodsControlledBy. Events
+// are best-effort: a subscriber that doesn't keep up with the channel will
+// have events dropped rather than stall indexing, so consumers that need an
+// authoritative view should re-read via PodsControlledBy after waking up on
+// an event rather than trust the event payload alone.
+//
+// The returned channel is never closed; callers must call the returned
+// CancelFunc once they're done observing to stop delivery and let the
+// subscription be garbage collected.
+func (p *ControlledPodsIndexer) Observe(obj interface{}) (<-chan PodSetEvent, CancelFunc, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, fmt.Errorf("object of type %T does not implement metav1.Object: %w", obj, err)
+	}
+
+	sub := &podSetSubscription{
+		rootUID: accessor.GetUID(),
+		ch:      make(chan PodSetEvent, podSetEventBufferSize),
+	}
+
+	p.mu.Lock()
+	p.subscriptions = append(p.subscriptions, sub)
+	p.mu.Unlock()
+
+	cancel := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, s := range p.subscriptions {
+			if s == sub {
+				p.subscriptions = append(p.subscriptions[:i:i], p.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+	return sub.ch, cancel, nil
+}
+
+// WaitForPodCount blocks until the number of pods transitively controlled
+// by obj equals n, or ctx is done, returning the matching pods.
+func (p *ControlledPodsIndexer) WaitForPodCount(ctx context.Context, obj interface{}, n int) ([]*corev1.Pod, error) {
+	// Subscribe before taking the initial snapshot: any Add/Update/Delete
+	// that lands between the two would otherwise be invisible to this
+	// subscription (it didn't exist yet when notify ran) and, if it was the
+	// very event bringing the count to n, would be missed forever - the
+	// loop below only re-snapshots in response to a received event.
+	events, cancel, err := p.Observe(obj)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	pods, err := p.PodsControlledBy(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(pods) != n {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for pod count to reach %d (last seen %d): %w", n, len(pods), ctx.Err())
+		case <-events:
+		}
+		if pods, err = p.PodsControlledBy(obj); err != nil {
+			return nil, err
+		}
+	}
+	return pods, nil
+}
+
+// notify delivers a PodSetEvent to every subscription whose observed object
+// is an ancestor of pod's direct controller.
+func (p *ControlledPodsIndexer) notify(eventType PodSetEventType, pod *corev1.Pod) {
+	controllerRef := metav1.GetControllerOf(pod)
+	if controllerRef == nil {
+		return
+	}
+
+	p.mu.RLock()
+	subs := append([]*podSetSubscription(nil), p.subscriptions...)
+	p.mu.RUnlock()
+
+	for _, sub := range subs {
+		if !p.ancestryIncludes(controllerRef.UID, sub.rootUID) {
+			continue
+		}
+		select {
+		case sub.ch <- PodSetEvent{Type: eventType, Pod: pod}:
+		default:
+			// Subscriber isn't keeping up; drop the event rather than
+			// block pod indexing on a slow consumer.
+		}
+	}
+}
+
+// notifyResync wakes up every subscription rooted at parentUID or one of
+// its ancestors, so that a new or changed owner-chain edge resolving into
+// parentUID (e.g. a ReplicaSet's owner reference resolving after its pods
+// are already indexed - the pod and owner-resolver informers sync
+// independently) isn't missed by a subscriber that's otherwise only woken up
+// by pod events.
+func (p *ControlledPodsIndexer) notifyResync(parentUID types.UID) {
+	p.mu.RLock()
+	subs := append([]*podSetSubscription(nil), p.subscriptions...)
+	p.mu.RUnlock()
+
+	for _, sub := range subs {
+		if !p.ancestryIncludes(parentUID, sub.rootUID) {
+			continue
+		}
+		select {
+		case sub.ch <- PodSetEvent{Type: PodSetEventResync}:
+		default:
+			// Subscriber isn't keeping up; drop the event rather than
+			// block owner-resolver indexing on a slow consumer.
+		}
+	}
+}
+
+// ancestryIncludes reports whether walking up the owner chain from uid
+// (inclusive) via the registered owner resolvers ever reaches target.
+func (p *ControlledPodsIndexer) ancestryIncludes(uid, target types.UID) bool {
+	for {
+		if uid == target {
+			return true
+		}
+		parent, ok := p.owners.parentOf(uid)
+		if !ok {
+			return false
+		}
+		uid = parent
+	}
+}
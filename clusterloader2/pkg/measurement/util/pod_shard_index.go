@@ -0,0 +1,154 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// podShardCount is the number of stripes the pod index is split across.
+// At clusterloader2 scale (150k+ pods), a single map+mutex serializes every
+// PodsControlledBy call against every informer event; striping by
+// controller UID spreads that contention across independent locks.
+const podShardCount = 256
+
+// podShardIndex is a map from controller UID to the pods it directly
+// controls. Structural changes (a controller UID being seen for the first
+// time) take mu exclusively; the pod slice for an already-known UID is an
+// atomic.Pointer, so readers and writers of an existing entry take mu only
+// as readers, to look the entry up, and update the slice itself without
+// holding it.
+type podShardIndex struct {
+	shards [podShardCount]podShard
+}
+
+type podShard struct {
+	mu      sync.RWMutex
+	entries map[types.UID]*atomic.Pointer[[]*corev1.Pod]
+}
+
+func newPodShardIndex() *podShardIndex {
+	idx := &podShardIndex{}
+	for i := range idx.shards {
+		idx.shards[i].entries = make(map[types.UID]*atomic.Pointer[[]*corev1.Pod])
+	}
+	return idx
+}
+
+// shardFor hashes uid into one of count shards. It's shared by every shard
+// index in this package (podShardIndex, ownerShardIndex, ...) so that
+// different UID spaces (pods, owner-chain edges, ...) can be striped
+// independently without duplicating the hashing logic.
+func shardFor(uid types.UID, count int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(uid))
+	return int(h.Sum32() % uint32(count))
+}
+
+// entry returns the atomic pointer backing uid's pod slice, creating it if
+// createIfMissing is set and it doesn't exist yet.
+func (idx *podShardIndex) entry(uid types.UID, createIfMissing bool) *atomic.Pointer[[]*corev1.Pod] {
+	shard := &idx.shards[shardFor(uid, podShardCount)]
+
+	shard.mu.RLock()
+	ptr := shard.entries[uid]
+	shard.mu.RUnlock()
+	if ptr != nil || !createIfMissing {
+		return ptr
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if ptr = shard.entries[uid]; ptr == nil {
+		ptr = &atomic.Pointer[[]*corev1.Pod]{}
+		shard.entries[uid] = ptr
+	}
+	return ptr
+}
+
+// load returns the pods currently indexed under uid. It takes the shard's
+// read lock only to find the entry in the shard's map, and then does an
+// atomic pointer load; it waits only while another goroutine holds the
+// shard's lock exclusively to add a new controller UID.
+func (idx *podShardIndex) load(uid types.UID) []*corev1.Pod {
+	ptr := idx.entry(uid, false)
+	if ptr == nil {
+		return nil
+	}
+	pods := ptr.Load()
+	if pods == nil {
+		return nil
+	}
+	return *pods
+}
+
+// add appends pod to uid's pod slice via a compare-and-swap loop, so
+// concurrent adds/removes for the same UID never block on each other.
+func (idx *podShardIndex) add(uid types.UID, pod *corev1.Pod) {
+	ptr := idx.entry(uid, true)
+	for {
+		old := ptr.Load()
+		next := append(append([]*corev1.Pod(nil), deref(old)...), pod)
+		if ptr.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+// remove deletes the pod with the given UID from controllerUID's pod slice,
+// if present.
+func (idx *podShardIndex) remove(controllerUID, podUID types.UID) {
+	ptr := idx.entry(controllerUID, false)
+	if ptr == nil {
+		return
+	}
+	for {
+		old := ptr.Load()
+		pods := deref(old)
+		i := indexOfPod(pods, podUID)
+		if i < 0 {
+			return
+		}
+		next := make([]*corev1.Pod, 0, len(pods)-1)
+		next = append(next, pods[:i]...)
+		next = append(next, pods[i+1:]...)
+		if ptr.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+func deref(pods *[]*corev1.Pod) []*corev1.Pod {
+	if pods == nil {
+		return nil
+	}
+	return *pods
+}
+
+func indexOfPod(pods []*corev1.Pod, uid types.UID) int {
+	for i, pod := range pods {
+		if pod.UID == uid {
+			return i
+		}
+	}
+	return -1
+}